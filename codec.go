@@ -0,0 +1,93 @@
+package mongo
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+
+	jsoniter "github.com/json-iterator/go"
+	"gopkg.in/oauth2.v3"
+	"gopkg.in/oauth2.v3/models"
+)
+
+// Codec marshals and unmarshals the token payload stored in basicData.Data
+type Codec interface {
+	Marshal(oauth2.TokenInfo) ([]byte, error)
+	Unmarshal([]byte) (oauth2.TokenInfo, error)
+}
+
+// JSONCodec is the default Codec, backed by encoding/json
+type JSONCodec struct{}
+
+// Marshal encodes info as JSON
+func (JSONCodec) Marshal(info oauth2.TokenInfo) ([]byte, error) {
+	return json.Marshal(info)
+}
+
+// Unmarshal decodes a JSON-encoded token
+func (JSONCodec) Unmarshal(data []byte) (oauth2.TokenInfo, error) {
+	var tm models.Token
+	if err := json.Unmarshal(data, &tm); err != nil {
+		return nil, err
+	}
+	return &tm, nil
+}
+
+var jsoniterAPI = jsoniter.ConfigCompatibleWithStandardLibrary
+
+// JSONIterCodec is a drop-in, faster replacement for JSONCodec backed by
+// github.com/json-iterator/go
+type JSONIterCodec struct{}
+
+// Marshal encodes info as JSON using json-iterator
+func (JSONIterCodec) Marshal(info oauth2.TokenInfo) ([]byte, error) {
+	return jsoniterAPI.Marshal(info)
+}
+
+// Unmarshal decodes a JSON-encoded token using json-iterator
+func (JSONIterCodec) Unmarshal(data []byte) (oauth2.TokenInfo, error) {
+	var tm models.Token
+	if err := jsoniterAPI.Unmarshal(data, &tm); err != nil {
+		return nil, err
+	}
+	return &tm, nil
+}
+
+// AEADCodec wraps another Codec and encrypts its output with the given
+// cipher.AEAD, prepending a random nonce to the ciphertext
+type AEADCodec struct {
+	Codec Codec
+	AEAD  cipher.AEAD
+}
+
+// Marshal encodes info with the wrapped Codec, then seals it with the AEAD
+func (c AEADCodec) Marshal(info oauth2.TokenInfo) ([]byte, error) {
+	plain, err := c.Codec.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, c.AEAD.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return c.AEAD.Seal(nonce, nonce, plain, nil), nil
+}
+
+// Unmarshal opens the AEAD-sealed payload, then decodes it with the wrapped Codec
+func (c AEADCodec) Unmarshal(data []byte) (oauth2.TokenInfo, error) {
+	nonceSize := c.AEAD.NonceSize()
+	if len(data) < nonceSize {
+		return nil, errors.New("mongo: encrypted token payload shorter than nonce")
+	}
+
+	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
+	plain, err := c.AEAD.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Codec.Unmarshal(plain)
+}
@@ -0,0 +1,92 @@
+package mongo
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"gopkg.in/oauth2.v3/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newTestTokenInfo() *models.Token {
+	return &models.Token{
+		ClientID:        "client1",
+		UserID:          "user1",
+		Access:          "access-token",
+		AccessCreateAt:  time.Now(),
+		AccessExpiresIn: time.Hour,
+	}
+}
+
+func TestJSONCodec(t *testing.T) {
+	Convey("Test JSONCodec round-trip", t, func() {
+		info := newTestTokenInfo()
+
+		data, err := JSONCodec{}.Marshal(info)
+		So(err, ShouldBeNil)
+
+		got, err := JSONCodec{}.Unmarshal(data)
+		So(err, ShouldBeNil)
+		So(got.GetAccess(), ShouldEqual, info.GetAccess())
+		So(got.GetUserID(), ShouldEqual, info.GetUserID())
+	})
+}
+
+func TestJSONIterCodec(t *testing.T) {
+	Convey("Test JSONIterCodec round-trip", t, func() {
+		info := newTestTokenInfo()
+
+		data, err := JSONIterCodec{}.Marshal(info)
+		So(err, ShouldBeNil)
+
+		got, err := JSONIterCodec{}.Unmarshal(data)
+		So(err, ShouldBeNil)
+		So(got.GetAccess(), ShouldEqual, info.GetAccess())
+	})
+}
+
+func newTestAEAD() cipher.AEAD {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
+
+func TestAEADCodec(t *testing.T) {
+	Convey("Test AEADCodec encrypts and decrypts the wrapped payload", t, func() {
+		info := newTestTokenInfo()
+		codec := AEADCodec{Codec: JSONCodec{}, AEAD: newTestAEAD()}
+
+		sealed, err := codec.Marshal(info)
+		So(err, ShouldBeNil)
+		So(sealed, ShouldNotResemble, []byte(nil))
+
+		plain, err := JSONCodec{}.Marshal(info)
+		So(err, ShouldBeNil)
+		So(sealed, ShouldNotResemble, plain)
+
+		got, err := codec.Unmarshal(sealed)
+		So(err, ShouldBeNil)
+		So(got.GetAccess(), ShouldEqual, info.GetAccess())
+	})
+
+	Convey("Test AEADCodec rejects payloads shorter than the nonce", t, func() {
+		codec := AEADCodec{Codec: JSONCodec{}, AEAD: newTestAEAD()}
+
+		_, err := codec.Unmarshal([]byte("short"))
+		So(err, ShouldNotBeNil)
+	})
+}
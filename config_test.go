@@ -0,0 +1,27 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNewConfig(t *testing.T) {
+	Convey("Test config options", t, func() {
+		cfg := NewConfig(context.Background(), "mongodb://localhost/db", "db",
+			WithReplicaSet("rs0"),
+			WithConnectTimeout(3*time.Second),
+			WithServerSelectionTimeout(3*time.Second),
+			WithMaxPoolSize(50),
+			WithMinPoolSize(5),
+		)
+
+		So(*cfg.ClientOptions().ReplicaSet, ShouldEqual, "rs0")
+		So(*cfg.ClientOptions().ConnectTimeout, ShouldEqual, 3*time.Second)
+		So(*cfg.ClientOptions().ServerSelectionTimeout, ShouldEqual, 3*time.Second)
+		So(*cfg.ClientOptions().MaxPoolSize, ShouldEqual, uint64(50))
+		So(*cfg.ClientOptions().MinPoolSize, ShouldEqual, uint64(5))
+	})
+}
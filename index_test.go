@@ -0,0 +1,31 @@
+package mongo
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIndexToIndexModel(t *testing.T) {
+	Convey("Test Index to mongo.IndexModel conversion", t, func() {
+		ttl := int32(0)
+		idx := Index{
+			Keys: []IndexKey{
+				{Key: "ClientID"},
+				{Key: "UserID", Desc: true},
+			},
+			Name:               "client_user",
+			Unique:             true,
+			Sparse:             true,
+			ExpireAfterSeconds: &ttl,
+		}
+
+		model := idx.toIndexModel()
+
+		So(model.Options.Name, ShouldNotBeNil)
+		So(*model.Options.Name, ShouldEqual, "client_user")
+		So(*model.Options.Unique, ShouldBeTrue)
+		So(*model.Options.Sparse, ShouldBeTrue)
+		So(*model.Options.ExpireAfterSeconds, ShouldEqual, int32(0))
+	})
+}
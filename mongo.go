@@ -2,6 +2,7 @@ package mongo
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -14,9 +15,15 @@ import (
 )
 
 const (
-	namespaceExistsErrCode int32 = 48
+	namespaceExistsErrCode      int32 = 48
+	indexOptionsConflictErrCode int32 = 85
 )
 
+// Storage is an oauth2.TokenStore that can also close its connection
+type Storage interface {
+	oauth2.TokenStore
+	Close() error
+}
 
 // IndexKey holds a key of index
 type IndexKey struct {
@@ -33,19 +40,116 @@ type Index struct {
 	ExpireAfterSeconds *int32
 }
 
+// toIndexModel builds the mongo-driver index model this Index describes
+func (idx Index) toIndexModel() mongo.IndexModel {
+	keys := bson.D{}
+	for _, k := range idx.Keys {
+		var v interface{} = 1
+		if k.Desc {
+			v = -1
+		}
+		keys = append(keys, bson.E{Key: k.Key, Value: v})
+	}
+
+	opts := options.Index().SetUnique(idx.Unique).SetSparse(idx.Sparse)
+	if idx.Name != "" {
+		opts.SetName(idx.Name)
+	}
+	if idx.ExpireAfterSeconds != nil {
+		opts.SetExpireAfterSeconds(*idx.ExpireAfterSeconds)
+	}
+
+	return mongo.IndexModel{Keys: keys, Options: opts}
+}
+
 // Config mongodb configuration parameters
 type Config struct {
 	ctx context.Context
 	URL string
 	DB  string
+
+	clientOpts *options.ClientOptions
 }
 
 // NewConfig create mongodb configuration
-func NewConfig(ctx context.Context, url, db string) *Config {
-	return &Config{
+func NewConfig(ctx context.Context, url, db string, opts ...ConfigOption) *Config {
+	cfg := &Config{
 		ctx: ctx,
 		URL: url,
 		DB:  db,
+		clientOpts: options.Client().
+			ApplyURI(url).
+			SetConnectTimeout(10 * time.Second),
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// ClientOptions returns the *options.ClientOptions this Config will connect
+// with, composed from the URL plus any ConfigOptions passed to NewConfig.
+func (cfg *Config) ClientOptions() *options.ClientOptions {
+	return cfg.clientOpts
+}
+
+// ConfigOption configures the underlying mongo-driver client options on a Config
+type ConfigOption func(*Config)
+
+// WithTLSConfig configures the driver to secure the connection with the given TLS config
+func WithTLSConfig(tlsCfg *tls.Config) ConfigOption {
+	return func(cfg *Config) {
+		cfg.clientOpts.SetTLSConfig(tlsCfg)
+	}
+}
+
+// WithCredential configures the driver to authenticate with the given credential (e.g. SCRAM)
+func WithCredential(cred options.Credential) ConfigOption {
+	return func(cfg *Config) {
+		cfg.clientOpts.SetAuth(cred)
+	}
+}
+
+// WithReplicaSet configures the name of the replica set to connect to
+func WithReplicaSet(name string) ConfigOption {
+	return func(cfg *Config) {
+		cfg.clientOpts.SetReplicaSet(name)
+	}
+}
+
+// WithConnectTimeout overrides the default 10s connect timeout
+func WithConnectTimeout(d time.Duration) ConfigOption {
+	return func(cfg *Config) {
+		cfg.clientOpts.SetConnectTimeout(d)
+	}
+}
+
+// WithServerSelectionTimeout configures how long the driver waits to select a server
+func WithServerSelectionTimeout(d time.Duration) ConfigOption {
+	return func(cfg *Config) {
+		cfg.clientOpts.SetServerSelectionTimeout(d)
+	}
+}
+
+// WithMaxPoolSize configures the maximum number of connections in the driver's pool
+func WithMaxPoolSize(n uint64) ConfigOption {
+	return func(cfg *Config) {
+		cfg.clientOpts.SetMaxPoolSize(n)
+	}
+}
+
+// WithMinPoolSize configures the minimum number of connections in the driver's pool
+func WithMinPoolSize(n uint64) ConfigOption {
+	return func(cfg *Config) {
+		cfg.clientOpts.SetMinPoolSize(n)
+	}
+}
+
+// WithClientOptions merges caller-supplied *options.ClientOptions on top of
+// the ones NewConfig already built, for anything not covered by a dedicated With* option
+func WithClientOptions(opts *options.ClientOptions) ConfigOption {
+	return func(cfg *Config) {
+		cfg.clientOpts = options.MergeClientOptions(cfg.clientOpts, opts)
 	}
 }
 
@@ -59,6 +163,26 @@ type TokenConfig struct {
 	AccessCName string
 	// store refresh token data collection name(The default is oauth2_refresh)
 	RefreshCName string
+	// store revoked refresh token collection name(The default is oauth2_revoked)
+	RevokedCName string
+
+	// RotateRefresh, when true, makes GetByRefresh consume the presented
+	// refresh token and record it as revoked instead of leaving it valid
+	// for reuse. Pair with DetectRefreshReuse to catch a stolen refresh
+	// token being replayed after it has already been rotated away.
+	RotateRefresh bool
+
+	// BasicIndexes, AccessIndexes and RefreshIndexes are additional indexes
+	// built on their respective collections alongside the ExpiredAt TTL
+	// index, e.g. a composite index on ClientID/UserID for admin queries.
+	BasicIndexes   []Index
+	AccessIndexes  []Index
+	RefreshIndexes []Index
+
+	// Codec marshals/unmarshals the token payload stored in basicData.Data.
+	// Defaults to JSONCodec; set it to an AEADCodec to encrypt tokens at
+	// rest.
+	Codec Codec
 }
 
 // NewDefaultTokenConfig create a default token configuration
@@ -68,15 +192,14 @@ func NewDefaultTokenConfig() *TokenConfig {
 		BasicCName:   "oauth2_basic",
 		AccessCName:  "oauth2_access",
 		RefreshCName: "oauth2_refresh",
+		RevokedCName: "oauth2_revoked",
+		Codec:        JSONCodec{},
 	}
 }
 
 // NewTokenStore create a token store instance based on mongodb
 func NewTokenStore(cfg *Config, tcfgs ...*TokenConfig) (store *TokenStore) {
-	opts := options.Client().
-		ApplyURI(cfg.URL).
-		SetConnectTimeout(10 * time.Second)
-	client, err := mongo.Connect(cfg.ctx, opts)
+	client, err := mongo.Connect(cfg.ctx, cfg.clientOpts)
 	if err != nil {
 		panic(err)
 	}
@@ -94,7 +217,13 @@ func NewTokenStoreWithClient(ctx context.Context, client *mongo.Client, dbName s
 	if len(tcfgs) > 0 {
 		ts.tcfg = tcfgs[0]
 	}
-	var ttl int32 = 60 * 1 // time.Second * 1
+	if ts.tcfg.Codec == nil {
+		ts.tcfg.Codec = JSONCodec{}
+	}
+	// ExpireAfterSeconds of 0 tells Mongo to expire a document exactly at
+	// the time stored in its ExpiredAt field, rather than N seconds after
+	// insertion.
+	var ttl int32 = 0
 	opts := &options.IndexOptions{
 		Name:               stringP("expire_after"),
 		Unique:             boolP(false),
@@ -109,6 +238,19 @@ func NewTokenStoreWithClient(ctx context.Context, client *mongo.Client, dbName s
 	ts.ensureIndex(ctx, ts.tcfg.BasicCName, expiredModel)
 	ts.ensureIndex(ctx, ts.tcfg.AccessCName, expiredModel)
 	ts.ensureIndex(ctx, ts.tcfg.RefreshCName, expiredModel)
+	if ts.tcfg.RotateRefresh {
+		ts.ensureIndex(ctx, ts.tcfg.RevokedCName, expiredModel)
+	}
+
+	for _, idx := range ts.tcfg.BasicIndexes {
+		ts.ensureIndex(ctx, ts.tcfg.BasicCName, idx.toIndexModel())
+	}
+	for _, idx := range ts.tcfg.AccessIndexes {
+		ts.ensureIndex(ctx, ts.tcfg.AccessCName, idx.toIndexModel())
+	}
+	for _, idx := range ts.tcfg.RefreshIndexes {
+		ts.ensureIndex(ctx, ts.tcfg.RefreshCName, idx.toIndexModel())
+	}
 
 	store = ts
 	return
@@ -122,6 +264,38 @@ func boolP(b bool) *bool {
 	return &b
 }
 
+// ensureIndex creates col (ignoring NamespaceExists errors so it is safe to
+// call repeatedly) and then builds index on it. If an index with the same
+// name already exists with different options (e.g. an ExpireAfterSeconds
+// left over from before a config change), it is dropped and recreated so
+// the new options actually take effect instead of being silently rejected.
+func ensureIndex(ctx context.Context, client *mongo.Client, dbName, col string, index mongo.IndexModel) error {
+	cmd := bson.D{{"create", col}}
+	if err := client.Database(dbName).RunCommand(ctx, cmd).Err(); err != nil {
+		// ignore NamespaceExists errors for idempotency
+		cmdErr, ok := err.(mongo.CommandError)
+		if !ok || cmdErr.Code != namespaceExistsErrCode {
+			return err
+		}
+	}
+
+	indexes := client.Database(dbName).Collection(col).Indexes()
+	_, err := indexes.CreateOne(ctx, index)
+	if err == nil {
+		return nil
+	}
+
+	cmdErr, ok := err.(mongo.CommandError)
+	if !ok || cmdErr.Code != indexOptionsConflictErrCode || index.Options == nil || index.Options.Name == nil {
+		return err
+	}
+	if _, derr := indexes.DropOne(ctx, *index.Options.Name); derr != nil {
+		return err
+	}
+	_, err = indexes.CreateOne(ctx, index)
+	return err
+}
+
 // TokenStore MongoDB storage for OAuth 2.0
 type TokenStore struct {
 	tcfg   *TokenConfig
@@ -130,21 +304,12 @@ type TokenStore struct {
 }
 
 // Close close the mongo session
-func (ts *TokenStore) Close() {
-	ts.Close()
+func (ts *TokenStore) Close() error {
+	return ts.client.Disconnect(context.Background())
 }
 
-func (ts *TokenStore) ensureIndex(ctx context.Context, col string, index mongo.IndexModel) error  {
-	cmd := bson.D{{"create", col}}
-	if err := ts.client.Database(ts.dbName).RunCommand(ctx, cmd).Err(); err != nil {
-		// ignore NamespaceExists errors for idempotency
-		cmdErr, ok := err.(mongo.CommandError)
-		if !ok || cmdErr.Code != namespaceExistsErrCode {
-			return err
-		}
-	}
-	_, err := ts.c(col).Indexes().CreateOne(ctx, index)
-	return err
+func (ts *TokenStore) ensureIndex(ctx context.Context, col string, index mongo.IndexModel) error {
+	return ensureIndex(ctx, ts.client, ts.dbName, col, index)
 }
 
 func (ts *TokenStore) c(name string) *mongo.Collection {
@@ -157,7 +322,7 @@ func (ts *TokenStore) cHandler(name string, handler func(c *mongo.Collection) er
 
 // Create create and store the new token information
 func (ts *TokenStore) Create(info oauth2.TokenInfo) error {
-	jv, err := json.Marshal(info)
+	jv, err := ts.tcfg.Codec.Marshal(info)
 	if err != nil {
 		return err
 	}
@@ -174,6 +339,8 @@ func (ts *TokenStore) Create(info oauth2.TokenInfo) error {
 				ID:        oid,
 				Data:      jv,
 				ExpiredAt: info.GetCodeCreateAt().Add(info.GetCodeExpiresIn()),
+				ClientID:  info.GetClientID(),
+				UserID:    info.GetUserID(),
 			})
 			return err
 		})
@@ -202,6 +369,8 @@ func (ts *TokenStore) Create(info oauth2.TokenInfo) error {
 			ID:        id,
 			Data:      jv,
 			ExpiredAt: rexp,
+			ClientID:  info.GetClientID(),
+			UserID:    info.GetUserID(),
 		}
 		if err = ts.cHandler(ts.tcfg.BasicCName, func(c *mongo.Collection) error {
 			_, err = c.InsertOne(sessionContext, basicCName)
@@ -220,7 +389,7 @@ func (ts *TokenStore) Create(info oauth2.TokenInfo) error {
 			BasicID:   id.Hex(),
 			ExpiredAt: aexp,
 		}
-		if err = ts.cHandler(ts.tcfg.BasicCName, func(c *mongo.Collection) error {
+		if err = ts.cHandler(ts.tcfg.AccessCName, func(c *mongo.Collection) error {
 			_, err = c.InsertOne(sessionContext, accessCName)
 			return err
 		}); err != nil {
@@ -237,7 +406,7 @@ func (ts *TokenStore) Create(info oauth2.TokenInfo) error {
 				BasicID:   id.Hex(),
 				ExpiredAt: rexp,
 			}
-			if err = ts.cHandler(ts.tcfg.BasicCName, func(c *mongo.Collection) error {
+			if err = ts.cHandler(ts.tcfg.RefreshCName, func(c *mongo.Collection) error {
 				_, err = c.InsertOne(sessionContext, refreshCName)
 				return err
 			}); err != nil {
@@ -309,12 +478,8 @@ func (ts *TokenStore) getData(basicID string) (ti oauth2.TokenInfo, err error) {
 			}
 			return verr
 		}
-		var tm models.Token
-		if err = json.Unmarshal(bd.Data, &tm); err != nil {
-			return err
-		}
-		ti = &tm
-		return nil
+		ti, verr = ts.tcfg.Codec.Unmarshal(bd.Data)
+		return verr
 	})
 	return
 }
@@ -352,6 +517,74 @@ func (ts *TokenStore) GetByAccess(access string) (ti oauth2.TokenInfo, err error
 	return
 }
 
+// ListByUserID returns every stored token belonging to userID
+func (ts *TokenStore) ListByUserID(userID string) (tis []oauth2.TokenInfo, err error) {
+	err = ts.cHandler(ts.tcfg.BasicCName, func(c *mongo.Collection) error {
+		ctx := context.Background()
+		cur, verr := c.Find(ctx, bson.M{"UserID": userID})
+		if verr != nil {
+			return verr
+		}
+		defer cur.Close(ctx)
+
+		for cur.Next(ctx) {
+			var bd basicData
+			if verr := cur.Decode(&bd); verr != nil {
+				return verr
+			}
+			ti, verr := ts.tcfg.Codec.Unmarshal(bd.Data)
+			if verr != nil {
+				return verr
+			}
+			tis = append(tis, ti)
+		}
+		return cur.Err()
+	})
+	return
+}
+
+// RevokeByUserID deletes every token belonging to userID
+func (ts *TokenStore) RevokeByUserID(userID string) error {
+	ctx := context.Background()
+
+	var basicIDs []string
+	if err := ts.cHandler(ts.tcfg.BasicCName, func(c *mongo.Collection) error {
+		cur, verr := c.Find(ctx, bson.M{"UserID": userID})
+		if verr != nil {
+			return verr
+		}
+		defer cur.Close(ctx)
+
+		for cur.Next(ctx) {
+			var bd basicData
+			if verr := cur.Decode(&bd); verr != nil {
+				return verr
+			}
+			basicIDs = append(basicIDs, bd.ID.Hex())
+		}
+		return cur.Err()
+	}); err != nil {
+		return err
+	}
+
+	if err := ts.cHandler(ts.tcfg.BasicCName, func(c *mongo.Collection) error {
+		_, verr := c.DeleteMany(ctx, bson.M{"UserID": userID})
+		return verr
+	}); err != nil {
+		return err
+	}
+	if err := ts.cHandler(ts.tcfg.AccessCName, func(c *mongo.Collection) error {
+		_, verr := c.DeleteMany(ctx, bson.M{"BasicID": bson.M{"$in": basicIDs}})
+		return verr
+	}); err != nil {
+		return err
+	}
+	return ts.cHandler(ts.tcfg.RefreshCName, func(c *mongo.Collection) error {
+		_, verr := c.DeleteMany(ctx, bson.M{"BasicID": bson.M{"$in": basicIDs}})
+		return verr
+	})
+}
+
 // GetByRefresh use the refresh token for token information data
 func (ts *TokenStore) GetByRefresh(refresh string) (ti oauth2.TokenInfo, err error) {
 	basicID, err := ts.getBasicID(ts.tcfg.RefreshCName, refresh)
@@ -359,13 +592,139 @@ func (ts *TokenStore) GetByRefresh(refresh string) (ti oauth2.TokenInfo, err err
 		return
 	}
 	ti, err = ts.getData(basicID)
+	if err != nil || ti == nil || !ts.tcfg.RotateRefresh {
+		return
+	}
+	err = ts.revokeRefresh(refresh, basicID, ti.GetRefreshCreateAt().Add(ti.GetRefreshExpiresIn()))
 	return
 }
 
+// revokeRefresh atomically removes the refresh token from RefreshCName and,
+// if it was still present, records it in RevokedCName so a later replay of
+// the same token can be caught by DetectRefreshReuse.
+func (ts *TokenStore) revokeRefresh(refresh, basicID string, expiredAt time.Time) error {
+	rId, err := primitive.ObjectIDFromHex(refresh)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	session, err := ts.client.StartSession()
+	if err != nil {
+		return err
+	}
+	if err := session.StartTransaction(); err != nil {
+		return err
+	}
+
+	if err = mongo.WithSession(ctx, session, func(sessionContext mongo.SessionContext) error {
+		found := true
+		if err := ts.cHandler(ts.tcfg.RefreshCName, func(c *mongo.Collection) error {
+			res := c.FindOneAndDelete(sessionContext, bson.M{"_id": rId})
+			if verr := res.Err(); verr != nil {
+				if verr == mongo.ErrNoDocuments {
+					found = false
+					return nil
+				}
+				return verr
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if !found {
+			return session.CommitTransaction(sessionContext)
+		}
+
+		if err := ts.cHandler(ts.tcfg.RevokedCName, func(rc *mongo.Collection) error {
+			_, verr := rc.InsertOne(sessionContext, revokedData{
+				ID:        rId,
+				BasicID:   basicID,
+				ExpiredAt: expiredAt,
+			})
+			return verr
+		}); err != nil {
+			return err
+		}
+
+		return session.CommitTransaction(sessionContext)
+	}); err != nil {
+		return err
+	}
+	session.EndSession(ctx)
+
+	return nil
+}
+
+// DetectRefreshReuse reports whether refresh was already consumed by a
+// prior rotation, revoking its token family if so
+func (ts *TokenStore) DetectRefreshReuse(refresh string) (reused bool, err error) {
+	rId, err := primitive.ObjectIDFromHex(refresh)
+	if err != nil {
+		return false, err
+	}
+
+	var basicID string
+	err = ts.cHandler(ts.tcfg.RevokedCName, func(c *mongo.Collection) error {
+		var rd revokedData
+		verr := c.FindOne(context.Background(), bson.M{"_id": rId}).Decode(&rd)
+		if verr != nil {
+			if verr == mongo.ErrNoDocuments {
+				return nil
+			}
+			return verr
+		}
+		reused = true
+		basicID = rd.BasicID
+		return nil
+	})
+	if err != nil || !reused {
+		return
+	}
+
+	err = ts.revokeFamily(basicID)
+	return
+}
+
+// revokeFamily deletes the basicData row and every access/refresh tokenData row sharing basicID
+func (ts *TokenStore) revokeFamily(basicID string) error {
+	ctx := context.Background()
+
+	bId, err := primitive.ObjectIDFromHex(basicID)
+	if err != nil {
+		return err
+	}
+	if err := ts.cHandler(ts.tcfg.BasicCName, func(c *mongo.Collection) error {
+		_, verr := c.DeleteOne(ctx, bson.M{"_id": bId})
+		return verr
+	}); err != nil {
+		return err
+	}
+	if err := ts.cHandler(ts.tcfg.AccessCName, func(c *mongo.Collection) error {
+		_, verr := c.DeleteMany(ctx, bson.M{"BasicID": basicID})
+		return verr
+	}); err != nil {
+		return err
+	}
+	return ts.cHandler(ts.tcfg.RefreshCName, func(c *mongo.Collection) error {
+		_, verr := c.DeleteMany(ctx, bson.M{"BasicID": basicID})
+		return verr
+	})
+}
+
+type revokedData struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	BasicID   string             `bson:"BasicID"`
+	ExpiredAt time.Time          `bson:"ExpiredAt"`
+}
+
 type basicData struct {
 	ID        primitive.ObjectID `bson:"_id"`
 	Data      []byte             `bson:"Data"`
 	ExpiredAt time.Time          `bson:"ExpiredAt"`
+	ClientID  string             `bson:"ClientID"`
+	UserID    string             `bson:"UserID"`
 }
 
 type tokenData struct {
@@ -373,3 +732,114 @@ type tokenData struct {
 	BasicID   string             `bson:"BasicID"`
 	ExpiredAt time.Time          `bson:"ExpiredAt"`
 }
+
+// ClientConfig client configuration parameters
+type ClientConfig struct {
+	// store client data collection name(The default is oauth2_clients)
+	ClientCName string
+}
+
+// NewDefaultClientConfig create a default client configuration
+func NewDefaultClientConfig() *ClientConfig {
+	return &ClientConfig{
+		ClientCName: "oauth2_clients",
+	}
+}
+
+// NewClientStore create a client store instance based on mongodb
+func NewClientStore(cfg *Config, ccfgs ...*ClientConfig) (store *ClientStore) {
+	client, err := mongo.Connect(cfg.ctx, cfg.clientOpts)
+	if err != nil {
+		panic(err)
+	}
+
+	return NewClientStoreWithClient(cfg.ctx, client, cfg.DB, ccfgs...)
+}
+
+// NewClientStoreWithClient create a client store instance based on mongodb
+func NewClientStoreWithClient(ctx context.Context, client *mongo.Client, dbName string, ccfgs ...*ClientConfig) (store *ClientStore) {
+	cs := &ClientStore{
+		dbName: dbName,
+		client: client,
+		ccfg:   NewDefaultClientConfig(),
+	}
+	if len(ccfgs) > 0 {
+		cs.ccfg = ccfgs[0]
+	}
+
+	store = cs
+	return
+}
+
+// ClientStore MongoDB storage for OAuth 2.0 client information
+type ClientStore struct {
+	ccfg   *ClientConfig
+	dbName string
+	client *mongo.Client
+}
+
+// Close close the mongo session
+func (cs *ClientStore) Close() error {
+	return cs.client.Disconnect(context.Background())
+}
+
+func (cs *ClientStore) cHandler(name string, handler func(c *mongo.Collection) error) error {
+	return handler(cs.client.Database(cs.dbName).Collection(name))
+}
+
+// Create create and store the new client information
+func (cs *ClientStore) Create(info oauth2.ClientInfo) error {
+	jv, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	return cs.cHandler(cs.ccfg.ClientCName, func(c *mongo.Collection) error {
+		_, err := c.InsertOne(context.Background(), clientData{
+			ID:   info.GetID(),
+			Data: jv,
+		})
+		return err
+	})
+}
+
+// GetByID use the client id for client information data
+func (cs *ClientStore) GetByID(id string) (ci oauth2.ClientInfo, err error) {
+	err = cs.cHandler(cs.ccfg.ClientCName, func(c *mongo.Collection) error {
+		var cd clientData
+		q := bson.M{"_id": id}
+		verr := c.FindOne(context.Background(), q).Decode(&cd)
+		if verr != nil {
+			if verr == mongo.ErrNoDocuments {
+				return nil
+			}
+			return verr
+		}
+		var cm models.Client
+		if err = json.Unmarshal(cd.Data, &cm); err != nil {
+			return err
+		}
+		ci = &cm
+		return nil
+	})
+	return
+}
+
+// RemoveByID use the client id to delete the client information
+func (cs *ClientStore) RemoveByID(id string) error {
+	return cs.cHandler(cs.ccfg.ClientCName, func(c *mongo.Collection) error {
+		q := bson.M{"_id": id}
+		_, verr := c.DeleteOne(context.Background(), q)
+		if verr != nil {
+			if verr == mongo.ErrNoDocuments {
+				return nil
+			}
+		}
+		return verr
+	})
+}
+
+type clientData struct {
+	ID   string `bson:"_id"`
+	Data []byte `bson:"Data"`
+}
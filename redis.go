@@ -0,0 +1,115 @@
+package mongo
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/redis/go-redis/v9"
+	"gopkg.in/oauth2.v3"
+	"gopkg.in/oauth2.v3/models"
+)
+
+// NewRedisTokenStore create a token store instance based on redis
+func NewRedisTokenStore(client *redis.Client, keyPrefix string) *RedisTokenStore {
+	return &RedisTokenStore{
+		client:    client,
+		keyPrefix: keyPrefix,
+	}
+}
+
+// RedisTokenStore token storage based on Redis(github.com/redis/go-redis)
+type RedisTokenStore struct {
+	client    *redis.Client
+	keyPrefix string
+}
+
+func (rs *RedisTokenStore) key(kind, token string) string {
+	return rs.keyPrefix + kind + ":" + token
+}
+
+// Create create and store the new token information
+func (rs *RedisTokenStore) Create(info oauth2.TokenInfo) error {
+	jv, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	if code := info.GetCode(); code != "" {
+		return rs.client.Set(ctx, rs.key("code", code), jv, info.GetCodeExpiresIn()).Err()
+	}
+
+	if access := info.GetAccess(); access != "" {
+		if err := rs.client.Set(ctx, rs.key("access", access), jv, info.GetAccessExpiresIn()).Err(); err != nil {
+			return err
+		}
+	}
+
+	if refresh := info.GetRefresh(); refresh != "" {
+		if err := rs.client.Set(ctx, rs.key("refresh", refresh), jv, info.GetRefreshExpiresIn()).Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (rs *RedisTokenStore) remove(key string) error {
+	err := rs.client.Del(context.Background(), key).Err()
+	if err == redis.Nil {
+		return nil
+	}
+	return err
+}
+
+// RemoveByCode use the authorization code to delete the token information
+func (rs *RedisTokenStore) RemoveByCode(code string) error {
+	return rs.remove(rs.key("code", code))
+}
+
+// RemoveByAccess use the access token to delete the token information
+func (rs *RedisTokenStore) RemoveByAccess(access string) error {
+	return rs.remove(rs.key("access", access))
+}
+
+// RemoveByRefresh use the refresh token to delete the token information
+func (rs *RedisTokenStore) RemoveByRefresh(refresh string) error {
+	return rs.remove(rs.key("refresh", refresh))
+}
+
+func (rs *RedisTokenStore) getData(key string) (oauth2.TokenInfo, error) {
+	jv, err := rs.client.Get(context.Background(), key).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var tm models.Token
+	if err := json.Unmarshal(jv, &tm); err != nil {
+		return nil, err
+	}
+	return &tm, nil
+}
+
+// GetByCode use the authorization code for token information data
+func (rs *RedisTokenStore) GetByCode(code string) (oauth2.TokenInfo, error) {
+	return rs.getData(rs.key("code", code))
+}
+
+// GetByAccess use the access token for token information data
+func (rs *RedisTokenStore) GetByAccess(access string) (oauth2.TokenInfo, error) {
+	return rs.getData(rs.key("access", access))
+}
+
+// GetByRefresh use the refresh token for token information data
+func (rs *RedisTokenStore) GetByRefresh(refresh string) (oauth2.TokenInfo, error) {
+	return rs.getData(rs.key("refresh", refresh))
+}
+
+// Close close the redis client
+func (rs *RedisTokenStore) Close() error {
+	return rs.client.Close()
+}
@@ -0,0 +1,39 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	neturl "net/url"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// NewTokenStoreFromURL create a Storage instance, picking the backend from
+// the URL scheme (mongodb://, mongodb+srv:// or redis://, rediss://).
+func NewTokenStoreFromURL(rawURL string) (Storage, error) {
+	u, err := neturl.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "mongodb", "mongodb+srv":
+		dbName := strings.TrimPrefix(u.Path, "/")
+		cfg := NewConfig(context.Background(), rawURL, dbName)
+		client, err := mongo.Connect(cfg.ctx, cfg.clientOpts)
+		if err != nil {
+			return nil, err
+		}
+		return NewTokenStoreWithClient(cfg.ctx, client, cfg.DB), nil
+	case "redis", "rediss":
+		opts, err := redis.ParseURL(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		return NewRedisTokenStore(redis.NewClient(opts), ""), nil
+	default:
+		return nil, fmt.Errorf("mongo: unsupported token store scheme %q", u.Scheme)
+	}
+}
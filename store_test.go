@@ -0,0 +1,29 @@
+package mongo
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNewTokenStoreFromURL(t *testing.T) {
+	Convey("Test token store backend selection", t, func() {
+		Convey("An unsupported scheme is rejected", func() {
+			store, err := NewTokenStoreFromURL("memcached://localhost:11211")
+			So(store, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("An unparsable URL is rejected", func() {
+			store, err := NewTokenStoreFromURL("://bad-url")
+			So(store, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("An unresolvable mongodb+srv host returns an error instead of panicking", func() {
+			store, err := NewTokenStoreFromURL("mongodb+srv://nosuchhost.invalid/db")
+			So(store, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}